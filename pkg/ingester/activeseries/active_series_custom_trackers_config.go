@@ -0,0 +1,640 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// Provenance-includes-location: https://github.com/cortexproject/cortex/blob/master/pkg/ingester/active_series_custom_trackers_config.go
+// Provenance-includes-license: Apache-2.0
+// Provenance-includes-copyright: The Cortex Authors.
+
+package activeseries
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// ActiveSeriesCustomTrackersConfig configures a set of named active series
+// trackers. Each tracker name is associated with a matcherExpr, which is
+// either a single PromQL-style label matcher set (e.g. `{foo="bar"}`) or a
+// logical composition of several of them built out of AND, OR and NOT, e.g.
+// `{env="prod"} AND {team="payments"} AND NOT {tier="canary"}`.
+type ActiveSeriesCustomTrackersConfig struct {
+	// source holds the original, unparsed expression for each tracker, so
+	// that String() can losslessly reproduce a valid flag value.
+	source map[string]string
+	// config holds the parsed expression tree for each tracker, used to
+	// evaluate whether a series belongs to it.
+	config map[string]matcherExpr
+}
+
+// NewActiveSeriesCustomTrackersConfig builds a new ActiveSeriesCustomTrackersConfig from a map of tracker name to matcher expression.
+func NewActiveSeriesCustomTrackersConfig(source map[string]string) (ActiveSeriesCustomTrackersConfig, error) {
+	m := ActiveSeriesCustomTrackersConfig{}
+
+	names := make([]string, 0, len(source))
+	for name := range source {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := m.add(-1, name, source[name]); err != nil {
+			return ActiveSeriesCustomTrackersConfig{}, err
+		}
+	}
+	return m, nil
+}
+
+// add inserts name:expr into m. index is the offending entry's position
+// for callers that have one (e.g. Parse, working off a semicolon-separated
+// list) and is otherwise -1 (e.g. when building from an unordered map).
+func (m *ActiveSeriesCustomTrackersConfig) add(index int, name, expr string) error {
+	if m.source == nil {
+		m.source = map[string]string{}
+		m.config = map[string]matcherExpr{}
+	}
+	if _, ok := m.source[name]; ok {
+		return newActiveSeriesCustomTrackersConfigParseError(index, parseErrorSideName, ReasonDuplicateName,
+			fmt.Sprintf("matcher %q for active series custom trackers is provided more than once", name))
+	}
+
+	parsed, err := parseMatcherExpr(expr)
+	if err != nil {
+		return newActiveSeriesCustomTrackersConfigParseError(index, parseErrorSideMatcher, ReasonInvalidMatcher,
+			errors.Wrapf(err, "can't build active series matcher %q", name).Error())
+	}
+
+	m.source[name] = expr
+	m.config[name] = parsed
+	return nil
+}
+
+// ActiveSeriesCustomTrackersConfigParseErrorReason is a stable,
+// machine-checkable code for why parsing an ActiveSeriesCustomTrackersConfig
+// flag value failed, so that callers can branch on the failure mode instead
+// of matching on the message text.
+type ActiveSeriesCustomTrackersConfigParseErrorReason string
+
+const (
+	// ReasonEmptySide means the name or the matcher side of a `<name>:<matcher>` entry was empty.
+	ReasonEmptySide ActiveSeriesCustomTrackersConfigParseErrorReason = "empty_side"
+	// ReasonDuplicateName means the same tracker name was provided more than once.
+	ReasonDuplicateName ActiveSeriesCustomTrackersConfigParseErrorReason = "duplicate_name"
+	// ReasonInvalidMatcher means the matcher expression itself failed to parse.
+	ReasonInvalidMatcher ActiveSeriesCustomTrackersConfigParseErrorReason = "invalid_matcher"
+)
+
+// parseErrorSide identifies which side of a `<name>:<matcher>` entry an
+// ActiveSeriesCustomTrackersConfigParseError is about.
+type parseErrorSide string
+
+const (
+	parseErrorSideName    parseErrorSide = "name"
+	parseErrorSideMatcher parseErrorSide = "matcher"
+	parseErrorSideUnknown parseErrorSide = ""
+)
+
+// ActiveSeriesCustomTrackersConfigParseError is returned by Parse (and, by
+// extension, by Set) when a flag value can't be parsed into an
+// ActiveSeriesCustomTrackersConfig.
+type ActiveSeriesCustomTrackersConfigParseError struct {
+	// Index is the zero-based position of the offending entry in the
+	// semicolon-separated input, or -1 if the error didn't originate from
+	// parsing such a list (e.g. it came from a map-based config).
+	Index int
+	// Side is which side of the offending `<name>:<matcher>` entry is at
+	// fault, or "" if that doesn't apply (e.g. an entry with no ":"
+	// separator at all).
+	Side parseErrorSide
+	// Reason is a stable code for why parsing failed.
+	Reason ActiveSeriesCustomTrackersConfigParseErrorReason
+
+	msg string
+}
+
+func newActiveSeriesCustomTrackersConfigParseError(index int, side parseErrorSide, reason ActiveSeriesCustomTrackersConfigParseErrorReason, msg string) *ActiveSeriesCustomTrackersConfigParseError {
+	return &ActiveSeriesCustomTrackersConfigParseError{Index: index, Side: side, Reason: reason, msg: msg}
+}
+
+func (e *ActiveSeriesCustomTrackersConfigParseError) Error() string {
+	return e.msg
+}
+
+// Equal reports whether m and other configure the same set of trackers,
+// each with the same matcher expression. Two expressions that are
+// semantically equivalent but spelled differently (e.g. different matcher
+// ordering) are considered different, same as for flag values compared via
+// String(). A YAML list form is stored as its AND-joined string form, so the
+// two compare equal.
+func (m *ActiveSeriesCustomTrackersConfig) Equal(other ActiveSeriesCustomTrackersConfig) bool {
+	return m.String() == other.String()
+}
+
+// ActiveSeriesCustomTrackersConfigDiff describes the per-tracker changes
+// between an old and a new ActiveSeriesCustomTrackersConfig, as returned by
+// Diff.
+type ActiveSeriesCustomTrackersConfigDiff struct {
+	// Added holds the names of trackers present in the new config but not in the old one.
+	Added []string
+	// Removed holds the names of trackers present in the old config but not in the new one.
+	Removed []string
+	// Changed holds the names of trackers present in both configs, but with a different matcher expression.
+	Changed []string
+}
+
+// IsEmpty reports whether the diff contains no changes at all.
+func (d ActiveSeriesCustomTrackersConfigDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Diff computes the per-tracker difference between m (the old config) and
+// next (the new config), by tracker name.
+func (m *ActiveSeriesCustomTrackersConfig) Diff(next ActiveSeriesCustomTrackersConfig) ActiveSeriesCustomTrackersConfigDiff {
+	var diff ActiveSeriesCustomTrackersConfigDiff
+
+	for name, expr := range next.source {
+		oldExpr, ok := m.source[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, name)
+		case oldExpr != expr:
+			diff.Changed = append(diff.Changed, name)
+		}
+	}
+	for name := range m.source {
+		if _, ok := next.source[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+	return diff
+}
+
+// MergeWithDefaults returns a new config containing every tracker in
+// defaults, overlaid with every tracker in m. A tracker name present in
+// both configs takes its matcher expression from m, not from defaults.
+func (m ActiveSeriesCustomTrackersConfig) MergeWithDefaults(defaults ActiveSeriesCustomTrackersConfig) ActiveSeriesCustomTrackersConfig {
+	merged := make(map[string]string, len(defaults.source)+len(m.source))
+	for name, expr := range defaults.source {
+		merged[name] = expr
+	}
+	for name, expr := range m.source {
+		merged[name] = expr
+	}
+
+	// Both m and defaults were already validated individually, and merging
+	// their already-parsed expressions can't introduce new parse errors or
+	// duplicate names, so this can't fail.
+	mergedConfig, _ := NewActiveSeriesCustomTrackersConfig(merged)
+	return mergedConfig
+}
+
+// Matches reports whether lbls satisfies the expression configured for the
+// tracker called name. It returns false if no such tracker is configured.
+func (m *ActiveSeriesCustomTrackersConfig) Matches(name string, lbls labels.Labels) bool {
+	expr, ok := m.config[name]
+	if !ok {
+		return false
+	}
+	return expr.matches(lbls)
+}
+
+// String implements flag.Value, and also yaml.Marshaler via MarshalYAML below.
+func (m *ActiveSeriesCustomTrackersConfig) String() string {
+	if m == nil || len(m.source) == 0 {
+		return ""
+	}
+
+	configEntries := make([]string, 0, len(m.source))
+	for name, expr := range m.source {
+		configEntries = append(configEntries, name+":"+expr)
+	}
+	sort.Strings(configEntries)
+
+	return strings.Join(configEntries, ";")
+}
+
+// Set implements flag.Value, parsing a semicolon-separated list of
+// `<name>:<matcher-expr>` pairs via Parse. It can be called multiple times
+// (once per occurrence of the flag on the command line), accumulating
+// trackers across calls: a name repeated across two calls to Set is
+// rejected the same way as a name repeated across two calls to add, not as
+// a within-one-value duplicate (that's Parse's job).
+func (m *ActiveSeriesCustomTrackersConfig) Set(s string) error {
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(parsed.source))
+	for name := range parsed.source {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := m.add(-1, name, parsed.source[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parse parses s — a semicolon-separated list of `<name>:<matcher-expr>`
+// entries, in the same format accepted by Set — into a standalone
+// ActiveSeriesCustomTrackersConfig. Unlike Set, Parse doesn't depend on or
+// mutate any existing configuration, which makes it re-entrant: calling it
+// twice with the same input always produces the same result, and it's safe
+// to use purely for validation (e.g. from a fuzz test or a config-file
+// linter). On failure it returns an *ActiveSeriesCustomTrackersConfigParseError.
+func Parse(s string) (ActiveSeriesCustomTrackersConfig, error) {
+	if s == "" {
+		return ActiveSeriesCustomTrackersConfig{}, nil
+	}
+
+	trackerConfigs := strings.Split(s, ";")
+	names := make([]string, 0, len(trackerConfigs))
+	exprs := make(map[string]string, len(trackerConfigs))
+	positions := make(map[string]int, len(trackerConfigs))
+
+	for i, config := range trackerConfigs {
+		nameAndExpr := strings.SplitN(config, ":", 2)
+		if len(nameAndExpr) != 2 || strings.TrimSpace(nameAndExpr[0]) == "" || strings.TrimSpace(nameAndExpr[1]) == "" {
+			side := parseErrorSideUnknown
+			if len(nameAndExpr) == 2 {
+				side = parseErrorSideMatcher
+				if strings.TrimSpace(nameAndExpr[0]) == "" {
+					side = parseErrorSideName
+				}
+			}
+			return ActiveSeriesCustomTrackersConfig{}, newActiveSeriesCustomTrackersConfigParseError(i, side, ReasonEmptySide,
+				fmt.Sprintf("semicolon-separated values should be <name>:<matcher>, but one of the sides was empty in the value %d: %q", i, config))
+		}
+
+		name := strings.TrimSpace(nameAndExpr[0])
+		expr := strings.TrimSpace(nameAndExpr[1])
+		if _, ok := exprs[name]; ok {
+			return ActiveSeriesCustomTrackersConfig{}, newActiveSeriesCustomTrackersConfigParseError(i, parseErrorSideName, ReasonDuplicateName,
+				fmt.Sprintf("matcher %q for active series custom trackers is provided twice", name))
+		}
+		exprs[name] = expr
+		positions[name] = i
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	cfg := ActiveSeriesCustomTrackersConfig{}
+	for _, name := range names {
+		if err := cfg.add(positions[name], name, exprs[name]); err != nil {
+			return ActiveSeriesCustomTrackersConfig{}, err
+		}
+	}
+	return cfg, nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler. Each tracker's value can either
+// be a plain matcher expression string, or a YAML list of matcher sets that
+// are AND-ed together, where an entry prefixed with `!` is negated.
+func (m *ActiveSeriesCustomTrackersConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	raw := map[string]interface{}{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	source := make(map[string]string, len(raw))
+	for name, value := range raw {
+		expr, err := yamlValueToMatcherExpr(value)
+		if err != nil {
+			return errors.Wrapf(err, "invalid active series custom tracker %q", name)
+		}
+		source[name] = expr
+	}
+
+	newConfig, err := NewActiveSeriesCustomTrackersConfig(source)
+	if err != nil {
+		return err
+	}
+	*m = newConfig
+	return nil
+}
+
+func yamlValueToMatcherExpr(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case []interface{}:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			entry, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("expected a string list entry, got %T", item)
+			}
+			entry = strings.TrimSpace(entry)
+			if strings.HasPrefix(entry, "!") {
+				entry = "NOT " + strings.TrimSpace(strings.TrimPrefix(entry, "!"))
+			}
+			parts = append(parts, entry)
+		}
+		return strings.Join(parts, " AND "), nil
+	default:
+		return "", fmt.Errorf("expected a string or a list of strings, got %T", value)
+	}
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (m ActiveSeriesCustomTrackersConfig) MarshalYAML() (interface{}, error) {
+	return m.source, nil
+}
+
+// matcherExpr is a node of the tree built out of a tracker's matcher
+// expression: either a leaf (a single `{...}` label matcher set) or an
+// AND/OR/NOT composition of other matcherExprs.
+type matcherExpr interface {
+	matches(lbls labels.Labels) bool
+}
+
+type leafMatcherExpr struct {
+	matchers []*labels.Matcher
+}
+
+func (l *leafMatcherExpr) matches(lbls labels.Labels) bool {
+	for _, matcher := range l.matchers {
+		if !matcher.Matches(lbls.Get(matcher.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+type andMatcherExpr struct {
+	children []matcherExpr
+}
+
+func (a *andMatcherExpr) matches(lbls labels.Labels) bool {
+	for _, child := range a.children {
+		if !child.matches(lbls) {
+			return false
+		}
+	}
+	return true
+}
+
+type orMatcherExpr struct {
+	children []matcherExpr
+}
+
+func (o *orMatcherExpr) matches(lbls labels.Labels) bool {
+	for _, child := range o.children {
+		if child.matches(lbls) {
+			return true
+		}
+	}
+	return false
+}
+
+type notMatcherExpr struct {
+	child matcherExpr
+}
+
+func (n *notMatcherExpr) matches(lbls labels.Labels) bool {
+	return !n.child.matches(lbls)
+}
+
+// parseMatcherExpr parses a tracker's matcher expression into a matcherExpr
+// tree. The grammar is:
+//
+//	expr  := orTerm
+//	orTerm  := andTerm ("OR" andTerm)*
+//	andTerm := notTerm ("AND" notTerm)*
+//	notTerm := "NOT" atom | atom
+//	atom  := "{" <PromQL label matchers> "}" | "(" expr ")"
+func parseMatcherExpr(expr string) (matcherExpr, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, errors.New("matcher expression is empty")
+	}
+
+	tokens, err := tokenizeMatcherExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("matcher expression is empty")
+	}
+
+	p := &matcherExprParser{tokens: tokens}
+	parsed, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected trailing input at token %d", p.pos)
+	}
+	return parsed, nil
+}
+
+type matcherExprTokenKind int
+
+const (
+	tokenEOF matcherExprTokenKind = iota
+	tokenLeaf
+	tokenAnd
+	tokenOr
+	tokenNot
+	tokenLParen
+	tokenRParen
+)
+
+type matcherExprToken struct {
+	kind matcherExprTokenKind
+	text string // raw "{...}" content, only set for tokenLeaf
+}
+
+func tokenizeMatcherExpr(expr string) ([]matcherExprToken, error) {
+	var tokens []matcherExprToken
+
+	i := 0
+	for i < len(expr) {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, matcherExprToken{kind: tokenLParen})
+			i++
+		case c == ')':
+			tokens = append(tokens, matcherExprToken{kind: tokenRParen})
+			i++
+		case c == '{':
+			end, err := matchBrace(expr, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, matcherExprToken{kind: tokenLeaf, text: expr[i : end+1]})
+			i = end + 1
+		default:
+			word, next := readWord(expr, i)
+			if word == "" {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", string(c), i)
+			}
+			switch strings.ToUpper(word) {
+			case "AND":
+				tokens = append(tokens, matcherExprToken{kind: tokenAnd})
+			case "OR":
+				tokens = append(tokens, matcherExprToken{kind: tokenOr})
+			case "NOT":
+				tokens = append(tokens, matcherExprToken{kind: tokenNot})
+			default:
+				return nil, fmt.Errorf("unexpected token %q, expected AND, OR, NOT or a {...} matcher set", word)
+			}
+			i = next
+		}
+	}
+	return tokens, nil
+}
+
+func readWord(expr string, start int) (string, int) {
+	i := start
+	for i < len(expr) {
+		switch expr[i] {
+		case ' ', '\t', '\n', '\r', '(', ')', '{':
+			return expr[start:i], i
+		}
+		i++
+	}
+	return expr[start:i], i
+}
+
+// matchBrace returns the index of the closing '}' matching the '{' at
+// start, ignoring braces inside quoted matcher values.
+func matchBrace(expr string, start int) (int, error) {
+	depth := 0
+	var quote byte
+	for i := start; i < len(expr); i++ {
+		c := expr[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+				continue
+			}
+			if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '"', '\'':
+			quote = c
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unterminated matcher set starting at %q", expr[start:])
+}
+
+type matcherExprParser struct {
+	tokens []matcherExprToken
+	pos    int
+}
+
+func (p *matcherExprParser) peek() matcherExprTokenKind {
+	if p.pos >= len(p.tokens) {
+		return tokenEOF
+	}
+	return p.tokens[p.pos].kind
+}
+
+func (p *matcherExprParser) parseOr() (matcherExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	children := []matcherExpr{left}
+	for p.peek() == tokenOr {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, right)
+	}
+
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &orMatcherExpr{children: children}, nil
+}
+
+func (p *matcherExprParser) parseAnd() (matcherExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+
+	children := []matcherExpr{left}
+	for p.peek() == tokenAnd {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, right)
+	}
+
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &andMatcherExpr{children: children}, nil
+}
+
+func (p *matcherExprParser) parseNot() (matcherExpr, error) {
+	if p.peek() == tokenNot {
+		p.pos++
+		child, err := p.parseAtom()
+		if err != nil {
+			return nil, err
+		}
+		return &notMatcherExpr{child: child}, nil
+	}
+	return p.parseAtom()
+}
+
+func (p *matcherExprParser) parseAtom() (matcherExpr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("unexpected end of matcher expression")
+	}
+
+	tok := p.tokens[p.pos]
+	switch tok.kind {
+	case tokenLeaf:
+		p.pos++
+		matchers, err := parser.ParseMetricSelector(tok.text)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid matcher set %q", tok.text)
+		}
+		return &leafMatcherExpr{matchers: matchers}, nil
+	case tokenLParen:
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != tokenRParen {
+			return nil, errors.New("expected closing parenthesis")
+		}
+		p.pos++
+		return inner, nil
+	default:
+		return nil, errors.New("expected a {...} matcher set, NOT or (")
+	}
+}
@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package activeseries
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FuzzActiveSeriesCustomTrackersConfig_ParseRoundTrip exercises Parse
+// (which Set is built on) and YAML (un)marshalling with random flag
+// values, asserting that:
+//   - whenever parsing succeeds, Parse(cfg.String()) reproduces an
+//     equivalent config, and so does a YAML marshal/unmarshal round trip;
+//   - whenever parsing fails, the error is an
+//     *ActiveSeriesCustomTrackersConfigParseError with a usable Index, and
+//     the message is stable across repeated parses of the same input.
+func FuzzActiveSeriesCustomTrackersConfig_ParseRoundTrip(f *testing.F) {
+	seeds := []string{
+		"",
+		`foo:{foo="bar"}`,
+		`foo:{foo="bar"};baz:{baz="bar"}`,
+		"foo:",
+		":{}",
+		" foo :\t{foo=\"bar\"}\n ",
+		`foo:{foo="bar"};foo:{boo="bam"}`,
+		`foo:{foo="b;a:r"}`,
+		`foo:{foo=""}`,
+		"日本語:{foo=\"bar\"}",
+		"foo:{foo=\"bar\"} ;baz:{baz=\"bar\"}",
+		`foo:{foo="bar"} AND {baz="qux"}`,
+		`foo:{foo="bar"} AND NOT {baz="qux"}`,
+	}
+	for _, s := range seeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		cfg, err := Parse(input)
+		if err != nil {
+			parseErr, ok := err.(*ActiveSeriesCustomTrackersConfigParseError)
+			if !ok {
+				t.Fatalf("Parse returned an error of unexpected type %T: %v", err, err)
+			}
+			if parseErr.Index < -1 {
+				t.Fatalf("parse error has an invalid index %d for input %q", parseErr.Index, input)
+			}
+
+			// Error messages must be stable: parsing the same input twice
+			// must produce an equal, non-empty message.
+			_, err2 := Parse(input)
+			if err2 == nil || err2.Error() != err.Error() {
+				t.Fatalf("Parse error message is not stable for input %q: %q vs %v", input, err.Error(), err2)
+			}
+			if err.Error() == "" {
+				t.Fatalf("parse error has an empty message for input %q", input)
+			}
+			return
+		}
+
+		// Parse(cfg.String()) must round-trip to an equivalent config.
+		str := cfg.String()
+		reparsed, err := Parse(str)
+		if err != nil {
+			t.Fatalf("Parse(cfg.String()) failed for input %q (cfg.String() = %q): %v", input, str, err)
+		}
+		if reparsed.String() != str {
+			t.Fatalf("Parse(cfg.String()) didn't round-trip for input %q: got %q, want %q", input, reparsed.String(), str)
+		}
+
+		// A YAML marshal/unmarshal round trip must also reproduce the same config.
+		marshaled, err := yaml.Marshal(cfg)
+		if err != nil {
+			t.Fatalf("yaml.Marshal failed for input %q: %v", input, err)
+		}
+		var fromYAML ActiveSeriesCustomTrackersConfig
+		if err := yaml.Unmarshal(marshaled, &fromYAML); err != nil {
+			t.Fatalf("yaml.Unmarshal(yaml.Marshal(cfg)) failed for input %q (yaml = %q): %v", input, marshaled, err)
+		}
+		if fromYAML.String() != str {
+			t.Fatalf("YAML round trip didn't match for input %q: got %q, want %q", input, fromYAML.String(), str)
+		}
+	})
+}
@@ -0,0 +1,234 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package activeseries
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// TrackerCounter is the active series counter for a single custom tracker.
+type TrackerCounter struct {
+	active atomic.Int64
+}
+
+// Inc increments the counter of active series matching this tracker.
+func (c *TrackerCounter) Inc() {
+	c.active.Add(1)
+}
+
+// Dec decrements the counter of active series matching this tracker.
+func (c *TrackerCounter) Dec() {
+	c.active.Add(-1)
+}
+
+// Active returns the current number of active series matching this tracker.
+func (c *TrackerCounter) Active() int64 {
+	return c.active.Load()
+}
+
+// trackerKey identifies a tracker's counter across config reloads. Two
+// trackers are considered "the same" counter-wise only if they share both
+// the tracker name and the unparsed matcher expression as stored in the
+// config (not normalised, so a respelling counts as a change); changing
+// either one starts the counter from zero.
+type trackerKey struct {
+	name   string
+	source string
+}
+
+// TenantCustomTrackers holds the compiled, per-tenant active series custom
+// trackers and their counters. It is owned by a single tenant's active
+// series bookkeeping and is safe for concurrent use while series are being
+// ingested and config reloads are applied.
+type TenantCustomTrackers struct {
+	mtx      sync.RWMutex
+	config   ActiveSeriesCustomTrackersConfig
+	counters map[trackerKey]*TrackerCounter
+}
+
+// NewTenantCustomTrackers builds a new TenantCustomTrackers initialised
+// with cfg.
+func NewTenantCustomTrackers(cfg ActiveSeriesCustomTrackersConfig) *TenantCustomTrackers {
+	t := &TenantCustomTrackers{}
+	t.Update(cfg)
+	return t
+}
+
+// Config returns the currently applied configuration.
+func (t *TenantCustomTrackers) Config() ActiveSeriesCustomTrackersConfig {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	return t.config
+}
+
+// Matches reports whether lbls belongs to the named tracker under the
+// currently applied configuration.
+func (t *TenantCustomTrackers) Matches(name string, lbls labels.Labels) bool {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	return t.config.Matches(name, lbls)
+}
+
+// Counter returns the counter for the named tracker under the currently
+// applied configuration, and whether that tracker currently exists.
+func (t *TenantCustomTrackers) Counter(name string) (*TrackerCounter, bool) {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+
+	expr, ok := t.config.source[name]
+	if !ok {
+		return nil, false
+	}
+	c, ok := t.counters[trackerKey{name: name, source: expr}]
+	return c, ok
+}
+
+// Update swaps the effective configuration for cfg. Any tracker whose name
+// and matcher expression are unchanged keeps its existing counter (so
+// reloading config does not reset series already counted); any tracker
+// that is new, or whose matcher expression changed under the same name,
+// gets a fresh zero-valued counter; any tracker that is no longer present
+// has its counter dropped (retired).
+//
+// Update is safe to call concurrently with counting series in and out via
+// the counters returned by Counter, including while a reload removes or
+// renames the very tracker a series is currently being counted against.
+func (t *TenantCustomTrackers) Update(cfg ActiveSeriesCustomTrackersConfig) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	counters := make(map[trackerKey]*TrackerCounter, len(cfg.source))
+	for name, expr := range cfg.source {
+		key := trackerKey{name: name, source: expr}
+		if existing, ok := t.counters[key]; ok {
+			counters[key] = existing
+			continue
+		}
+		counters[key] = &TrackerCounter{}
+	}
+
+	t.config = cfg
+	t.counters = counters
+}
+
+// CustomTrackersLoader loads the current set of per-tenant active series
+// custom trackers overrides, e.g. by parsing a YAML file on disk or reading
+// a runtime-config source. It is called once synchronously on
+// NewCustomTrackersOverridesManager and then again on every reload tick.
+type CustomTrackersLoader func() (map[string]ActiveSeriesCustomTrackersConfig, error)
+
+// CustomTrackersOverridesManager periodically reloads per-tenant
+// ActiveSeriesCustomTrackersConfig overrides via a CustomTrackersLoader and
+// merges each of them with a default configuration, so that the effective
+// per-tenant trackers can change at runtime without an ingester restart.
+type CustomTrackersOverridesManager struct {
+	defaults ActiveSeriesCustomTrackersConfig
+	load     CustomTrackersLoader
+
+	mtx           sync.RWMutex
+	overrides     map[string]ActiveSeriesCustomTrackersConfig
+	version       uint64
+	lastReloadErr error
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCustomTrackersOverridesManager creates a manager that merges
+// defaults with per-tenant overrides from load, refreshing them every
+// reloadInterval. The first load happens synchronously: if it fails, no
+// manager is returned.
+func NewCustomTrackersOverridesManager(defaults ActiveSeriesCustomTrackersConfig, load CustomTrackersLoader, reloadInterval time.Duration) (*CustomTrackersOverridesManager, error) {
+	m := &CustomTrackersOverridesManager{
+		defaults: defaults,
+		load:     load,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+
+	go m.run(reloadInterval)
+	return m, nil
+}
+
+func (m *CustomTrackersOverridesManager) run(interval time.Duration) {
+	defer close(m.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			_ = m.Reload()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Reload loads the per-tenant overrides, merges each with the defaults, and
+// swaps them in atomically. On error the previously loaded overrides keep
+// being served, and the error is recorded for LastReloadError.
+func (m *CustomTrackersOverridesManager) Reload() error {
+	loaded, err := m.load()
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.lastReloadErr = err
+	if err != nil {
+		return err
+	}
+
+	merged := make(map[string]ActiveSeriesCustomTrackersConfig, len(loaded))
+	for tenant, override := range loaded {
+		merged[tenant] = override.MergeWithDefaults(m.defaults)
+	}
+
+	m.overrides = merged
+	m.version++
+	return nil
+}
+
+// Config returns the effective ActiveSeriesCustomTrackersConfig for tenant,
+// falling back to the defaults if no override is configured for it.
+func (m *CustomTrackersOverridesManager) Config(tenant string) ActiveSeriesCustomTrackersConfig {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	if cfg, ok := m.overrides[tenant]; ok {
+		return cfg
+	}
+	return m.defaults
+}
+
+// Version returns a counter incremented on every successful reload, even
+// one that didn't change any tenant's effective configuration. Callers can
+// cache the last version they observed to cheaply skip calling Config (and
+// TenantCustomTrackers.Update) when nothing changed.
+func (m *CustomTrackersOverridesManager) Version() uint64 {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return m.version
+}
+
+// LastReloadError returns the error from the most recent reload attempt,
+// or nil if it succeeded.
+func (m *CustomTrackersOverridesManager) LastReloadError() error {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return m.lastReloadErr
+}
+
+// Stop terminates the background reload loop, blocking until it has
+// exited.
+func (m *CustomTrackersOverridesManager) Stop() {
+	close(m.stop)
+	<-m.done
+}
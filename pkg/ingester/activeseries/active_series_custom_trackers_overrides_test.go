@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package activeseries
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+)
+
+func mustConfig(t *testing.T, source map[string]string) ActiveSeriesCustomTrackersConfig {
+	cfg, err := NewActiveSeriesCustomTrackersConfig(source)
+	require.NoError(t, err)
+	return cfg
+}
+
+func TestTenantCustomTrackers_ReloadWhileIngesting(t *testing.T) {
+	cfg := mustConfig(t, map[string]string{"foo": `{foo="bar"}`})
+	tenant := NewTenantCustomTrackers(cfg)
+
+	counter, ok := tenant.Counter("foo")
+	require.True(t, ok)
+	counter.Inc()
+	counter.Inc()
+	require.EqualValues(t, 2, counter.Active())
+
+	// Reloading with an unchanged tracker must preserve the counter.
+	tenant.Update(mustConfig(t, map[string]string{"foo": `{foo="bar"}`}))
+
+	counterAfterReload, ok := tenant.Counter("foo")
+	require.True(t, ok)
+	require.Same(t, counter, counterAfterReload)
+	require.EqualValues(t, 2, counterAfterReload.Active())
+}
+
+func TestTenantCustomTrackers_RemovalMidFlight(t *testing.T) {
+	cfg := mustConfig(t, map[string]string{
+		"foo": `{foo="bar"}`,
+		"baz": `{baz="bar"}`,
+	})
+	tenant := NewTenantCustomTrackers(cfg)
+
+	fooCounter, ok := tenant.Counter("foo")
+	require.True(t, ok)
+	fooCounter.Inc()
+
+	// Drop "baz" from the config while "foo" is still being counted.
+	tenant.Update(mustConfig(t, map[string]string{"foo": `{foo="bar"}`}))
+
+	_, ok = tenant.Counter("baz")
+	require.False(t, ok, "removed tracker should be retired")
+
+	fooCounterAfterReload, ok := tenant.Counter("foo")
+	require.True(t, ok)
+	require.Same(t, fooCounter, fooCounterAfterReload)
+	require.EqualValues(t, 1, fooCounterAfterReload.Active())
+}
+
+func TestTenantCustomTrackers_Rename(t *testing.T) {
+	cfg := mustConfig(t, map[string]string{"foo": `{foo="bar"}`})
+	tenant := NewTenantCustomTrackers(cfg)
+
+	oldCounter, ok := tenant.Counter("foo")
+	require.True(t, ok)
+	oldCounter.Inc()
+	oldCounter.Inc()
+	oldCounter.Inc()
+
+	// Rename "foo" to "renamed", keeping the same matcher expression.
+	tenant.Update(mustConfig(t, map[string]string{"renamed": `{foo="bar"}`}))
+
+	_, ok = tenant.Counter("foo")
+	require.False(t, ok, "old name should be retired")
+
+	newCounter, ok := tenant.Counter("renamed")
+	require.True(t, ok)
+	require.NotSame(t, oldCounter, newCounter)
+	require.EqualValues(t, 0, newCounter.Active(), "new name should start from zero")
+}
+
+func TestTenantCustomTrackers_ChangedMatcherUnderSameNameStartsFromZero(t *testing.T) {
+	cfg := mustConfig(t, map[string]string{"foo": `{foo="bar"}`})
+	tenant := NewTenantCustomTrackers(cfg)
+
+	oldCounter, ok := tenant.Counter("foo")
+	require.True(t, ok)
+	oldCounter.Inc()
+
+	tenant.Update(mustConfig(t, map[string]string{"foo": `{foo="baz"}`}))
+
+	newCounter, ok := tenant.Counter("foo")
+	require.True(t, ok)
+	require.NotSame(t, oldCounter, newCounter)
+	require.EqualValues(t, 0, newCounter.Active())
+}
+
+func TestCustomTrackersOverridesManager(t *testing.T) {
+	defaults := mustConfig(t, map[string]string{"default-tracker": `{env="prod"}`})
+
+	var loaded map[string]ActiveSeriesCustomTrackersConfig
+	loader := func() (map[string]ActiveSeriesCustomTrackersConfig, error) {
+		return loaded, nil
+	}
+
+	loaded = map[string]ActiveSeriesCustomTrackersConfig{
+		"tenant-a": mustConfig(t, map[string]string{"foo": `{foo="bar"}`}),
+	}
+
+	m, err := NewCustomTrackersOverridesManager(defaults, loader, time.Hour)
+	require.NoError(t, err)
+	t.Cleanup(m.Stop)
+
+	require.NoError(t, m.LastReloadError())
+	initialVersion := m.Version()
+
+	tenantACfg := m.Config("tenant-a")
+	fooSeries := labels.FromStrings("foo", "bar")
+	prodSeries := labels.FromStrings("env", "prod")
+	require.True(t, tenantACfg.Matches("foo", fooSeries))
+	require.True(t, tenantACfg.Matches("default-tracker", prodSeries))
+
+	// Tenant with no override falls back to defaults only.
+	tenantBCfg := m.Config("tenant-b")
+	require.Equal(t, defaults.String(), tenantBCfg.String())
+
+	// Change tenant-a's override and reload.
+	loaded = map[string]ActiveSeriesCustomTrackersConfig{
+		"tenant-a": mustConfig(t, map[string]string{"foo": `{foo="qux"}`}),
+	}
+	require.NoError(t, m.Reload())
+	require.Greater(t, m.Version(), initialVersion)
+
+	updatedCfg := m.Config("tenant-a")
+	require.False(t, updatedCfg.Equal(tenantACfg))
+}